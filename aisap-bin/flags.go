@@ -3,8 +3,11 @@ package main
 import (
     "fmt"
     "os"
+    "strings"
 
     flag "github.com/spf13/pflag"
+
+    aisap "github.com/mgord9518/aisap"
 )
 
 type arrayFlags []string
@@ -18,12 +21,16 @@ var (
     // Long-only flags
     permFile = flag.String("profile", "", "")
     level    = flag.Int("level",        -1, "")
+    verify   = flag.Bool("verify",   false, "")
+    identity = flag.String("identity", "", "")
+    output   = flag.String("output", "", "")
 
 	// Flags that can be called multiple times
 	addFile  arrayFlags
 	addDev   arrayFlags
 	addSoc   arrayFlags
 	addShare arrayFlags
+	keyring  arrayFlags
 )
 
 // Initialization of global variables and help menu
@@ -34,6 +41,7 @@ func init() {
     flag.Var(&addDev,   "device", "")
     flag.Var(&addSoc,   "socket", "")
     flag.Var(&addShare, "share",  "")
+    flag.Var(&keyring,  "keyring", "")
 
 	// Prefer AppImage-provided variable `ARGV0` if present
     if argv0, present = os.LookupEnv("ARGV0"); !present {
@@ -59,7 +67,11 @@ func init() {
 	fmt.Printf("%s  --share   %sAdd share to sandbox (eg: network)\n", g, z)
         fmt.Printf("%s  --device  %sAllow access to additional /dev files\n", g ,z)
         fmt.Printf("%s  --level   %sChange the base security level of the sandbox (min: 0, max: 3)\n", g, z)
-        fmt.Printf("%s  --profile %sLook for permissions in this entry instead of the AppImage\n\n", g, z)
+        fmt.Printf("%s  --profile %sLook for permissions in this entry instead of the AppImage\n", g, z)
+        fmt.Printf("%s  --verify  %sRefuse to run the AppImage unless its signature checks out\n", g, z)
+        fmt.Printf("%s  --keyring %sTrust this key when verifying (can be given multiple times)\n", g, z)
+        fmt.Printf("%s  --identity%sX25519 private key used to decrypt an encrypted AppImage\n", g, z)
+        fmt.Printf("%s  --output  %sExport the mount instead of sandboxing it: type=dir|tar|oci-layer,dest=PATH[,include=PAT;PAT][,exclude=PAT;PAT][,preserve-perms][,deref-symlinks][,reproducible]\n\n", g, z)
         fmt.Printf("%sExamples:%s\n", y, z)
         fmt.Printf("  %s%s --profile%s=./f.desktop -- ./f.app\n", g, argv0, z)
         fmt.Printf("    Sandbox `f.app` using permissions from `f.desktop`\n\n")
@@ -67,6 +79,8 @@ func init() {
         fmt.Printf("    Tighten `f.app` sandbox to level 2 (default: 1)\n\n")
         fmt.Printf("  %s%s --file%s=./f.txt %s--file%s ./other.bin ./f.app\n", g, argv0, z, g, z)
         fmt.Printf("    Allow sandbox to access files `f.txt` and `other.bin`\n\n")
+        fmt.Printf("  %s%s --output%s=type=tar,dest=./f.tar ./f.app\n", g, argv0, z)
+        fmt.Printf("    Export the AppImage's contents to `f.tar` instead of sandboxing it\n\n")
         fmt.Printf("%sWARNING:%s No sandbox is impossible to escape! This is to *aid* security, not\n", r, z)
         fmt.Printf("guarentee safety when downloading sketchy stuff online. Don't be stupid!\n\n")
         fmt.Printf("Plus, this is ALPHA software! Very little testing has been done;\n")
@@ -92,4 +106,51 @@ func (i *arrayFlags) String() string {
 
 func (i *arrayFlags) Type() string {
     return ""
+}
+
+// parseOutputFlag parses a BuildKit-style `--output` value
+// (`type=...,dest=...[,include=a;b][,exclude=a;b][,preserve-perms]
+// [,deref-symlinks][,reproducible]`) into an aisap.ExportSpec
+func parseOutputFlag(s string) (aisap.ExportSpec, error) {
+    var spec aisap.ExportSpec
+
+    for _, field := range strings.Split(s, ",") {
+        if field == "" {
+            continue
+        }
+
+        key, value, hasValue := strings.Cut(field, "=")
+
+        switch key {
+        case "type":
+            spec.Type = value
+        case "dest":
+            spec.Dest = value
+        case "include":
+            spec.Include = strings.Split(value, ";")
+        case "exclude":
+            spec.Exclude = strings.Split(value, ";")
+        case "preserve-perms":
+            spec.PreservePerms = true
+        case "deref-symlinks":
+            spec.DerefSymlinks = true
+        case "reproducible":
+            spec.Reproducible = true
+        default:
+            return spec, fmt.Errorf("--output: unknown field %q", key)
+        }
+
+        if hasValue && value == "" {
+            return spec, fmt.Errorf("--output: field %q has no value", key)
+        }
+    }
+
+    if spec.Type == "" {
+        return spec, fmt.Errorf("--output: missing required field \"type\"")
+    }
+    if spec.Dest == "" {
+        return spec, fmt.Errorf("--output: missing required field \"dest\"")
+    }
+
+    return spec, nil
 }
\ No newline at end of file
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseOutputFlag(t *testing.T) {
+	spec, err := parseOutputFlag("type=tar,dest=./f.tar,include=a;b,exclude=c,preserve-perms,deref-symlinks,reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Type != "tar" || spec.Dest != "./f.tar" {
+		t.Fatalf("unexpected type/dest: %+v", spec)
+	}
+	if len(spec.Include) != 2 || spec.Include[0] != "a" || spec.Include[1] != "b" {
+		t.Fatalf("unexpected include: %v", spec.Include)
+	}
+	if len(spec.Exclude) != 1 || spec.Exclude[0] != "c" {
+		t.Fatalf("unexpected exclude: %v", spec.Exclude)
+	}
+	if !spec.PreservePerms || !spec.DerefSymlinks || !spec.Reproducible {
+		t.Fatalf("expected all boolean fields set: %+v", spec)
+	}
+}
+
+func TestParseOutputFlagRequiresTypeAndDest(t *testing.T) {
+	if _, err := parseOutputFlag("dest=./f.tar"); err == nil {
+		t.Fatalf("expected an error when type is missing")
+	}
+	if _, err := parseOutputFlag("type=tar"); err == nil {
+		t.Fatalf("expected an error when dest is missing")
+	}
+}
+
+func TestParseOutputFlagRejectsUnknownField(t *testing.T) {
+	if _, err := parseOutputFlag("type=tar,dest=./f.tar,bogus=1"); err == nil {
+		t.Fatalf("expected an error for an unknown field")
+	}
+}
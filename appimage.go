@@ -7,6 +7,7 @@ package aisap
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"path/filepath"
@@ -15,9 +16,12 @@ import (
 	"os/user"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	ini         "gopkg.in/ini.v1"
+	digest      "github.com/opencontainers/go-digest"
+	contenthash "github.com/mgord9518/aisap/contenthash"
 	helpers     "github.com/mgord9518/aisap/helpers"
 	permissions "github.com/mgord9518/aisap/permissions"
 	imgconv     "github.com/mgord9518/imgconv"
@@ -50,9 +54,20 @@ type AppImage struct {
 	Offset       int    // Offset of SquashFS image
 	imageType    int    // Type of AppImage (either 1 or 2)
 	rmMountDir   bool   // Type of AppImage (either 1 or 2)
+	uidRange     permissions.UidRange   // Host->sandbox uid/gid mapping for the user namespace
+	cacheKey     string                 // Path+mtime key this AppImage's mount is registered under
+	cache        *contenthash.CacheContext // Digest cache/refcount for this AppImage's mount
+	bwrapPid     int                    // PID of the running `bwrap` child, once launched
 }
 
 func NewAppImage(src string) (*AppImage, error) {
+	return newAppImage(src, nil)
+}
+
+// newAppImage is the shared implementation behind NewAppImage and
+// NewAppImageWithOptions; `identity` is only consulted for a type 3
+// (encrypted) AppImage, where it's required to unwrap the data key
+func newAppImage(src string, identity *[32]byte) (*AppImage, error) {
 	var err error
 
 	ai := &AppImage{}
@@ -62,20 +77,84 @@ func NewAppImage(src string) (*AppImage, error) {
 	ai.tempDir, err = helpers.MakeTemp("/tmp", ".aisapTemp_"+ai.RunId())
 	if err != nil { return nil, err }
 
-	ai.mountDir, err = helpers.MakeTemp(ai.TempDir(), ".mount_"+ai.RunId())
-	ai.rmMountDir = true
-
 	ai.Offset, err = helpers.GetOffset(src)
 	if err != nil { return nil, err }
 
-	err = Mount(src, ai.mountDir, ai.Offset)
-	if err != nil { return nil, err }
+	if fi, statErr := os.Stat(src); statErr == nil {
+		ai.cacheKey = src + ":" + strconv.FormatInt(fi.ModTime().UnixNano(), 10)
+	}
+
+	// Reuse an already-mounted copy of this exact AppImage (same path,
+	// unchanged mtime) rather than mounting it again
+	if ai.cacheKey != "" {
+		if cc, hit := contenthash.Acquire(ai.cacheKey); hit {
+			ai.mountDir = cc.Root()
+			ai.cache = cc
+			ai.rmMountDir = false
+		}
+	}
+
+	if ai.mountDir == "" {
+		ai.mountDir, err = helpers.MakeTemp(ai.TempDir(), ".mount_"+ai.RunId())
+		ai.rmMountDir = true
+
+		if err := mountSource(src, ai.mountDir, ai.Offset, identity); err != nil {
+			return nil, err
+		}
+
+		if ai.cacheKey != "" {
+			mountDir, tempDir := ai.mountDir, ai.tempDir
+			ai.cache = contenthash.Register(ai.cacheKey, mountDir, func() {
+				exec.Command("fusermount", "-uz", mountDir).Run()
+				os.RemoveAll(tempDir)
+			})
+		}
+	}
+
+	if err := finishLoad(ai); err != nil {
+		return nil, err
+	}
+
+    return ai, nil
+}
+
+// mountSource mounts `src` at `mountDir`. A type 3 (encrypted) AppImage is
+// decrypted into an anonymous memfd first (which requires `identity`); any
+// other type is mounted directly at `offset`
+func mountSource(src, mountDir string, offset int, identity *[32]byte) error {
+	format, err := helpers.GetAppImageType(src)
+	if err != nil {
+		return err
+	}
+
+	if format != 3 {
+		return Mount(src, mountDir, offset)
+	}
+
+	if identity == nil {
+		return errors.New("AppImage is encrypted; open it with NewAppImageWithOptions and an Identity")
+	}
 
+	memfdPath, err := decryptToMemfd(src, offset, *identity)
+	if err != nil {
+		return err
+	}
+
+	return Mount(memfdPath, mountDir, 0)
+}
+
+// finishLoad parses the desktop entry and permissions out of an already
+// mounted AppImage; shared by NewAppImage and NewAppImageAt
+func finishLoad(ai *AppImage) error {
 	// Return all `.desktop` files. A vadid AppImage should only have one
 	fp, err := filepath.Glob(ai.mountDir + "/*.desktop")
-	if err != nil { return nil, err }
+	if err != nil { return err }
+	if len(fp) == 0 {
+		return errors.New("no desktop entry found inside AppImage")
+	}
 
 	e, err := ioutil.ReadFile(fp[0])
+	if err != nil { return err }
 	entry, _ := ini.Load(e)
 
 	ai.Desktop  = entry
@@ -87,10 +166,79 @@ func NewAppImage(src string) (*AppImage, error) {
 	}
 
 	ai.Perms, _ = getPermsFromAppImage(ai)
-	ai.SetLevel(ai.Perms.Level)
 
+	return ai.SetLevel(ai.Perms.Level)
+}
+
+// NewAppImageAt behaves like NewAppImage, but mounts at the exact
+// `tempDir`/`mountDir` given instead of generating fresh random ones. Used
+// by the `checkpoint` package to re-mount an AppImage at the paths recorded
+// in a checkpoint manifest, since CRIU's external mount descriptors were
+// bound to those specific paths at dump time
+func NewAppImageAt(src, tempDir, mountDir string, offset int) (*AppImage, error) {
+	ai := &AppImage{}
+	ai.Path = src
+	ai.runId = helpers.RandString(int(time.Now().UTC().UnixNano()), 8)
+	ai.tempDir = tempDir
+	ai.mountDir = mountDir
+	ai.Offset = offset
+
+	if err := os.MkdirAll(ai.tempDir, 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(ai.mountDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := Mount(src, ai.mountDir, ai.Offset); err != nil {
+		return nil, err
+	}
+	ai.rmMountDir = true
+
+	if fi, statErr := os.Stat(src); statErr == nil {
+		ai.cacheKey = src + ":" + strconv.FormatInt(fi.ModTime().UnixNano(), 10)
+	}
+	if ai.cacheKey != "" {
+		mountDir, tempDir := ai.mountDir, ai.tempDir
+		ai.cache = contenthash.Register(ai.cacheKey, mountDir, func() {
+			exec.Command("fusermount", "-uz", mountDir).Run()
+			os.RemoveAll(tempDir)
+		})
+	}
+
+	if err := finishLoad(ai); err != nil {
+		return nil, err
+	}
+
+	return ai, nil
+}
 
-    return ai, err
+// Options extends NewAppImage with behavior that must be applied before the
+// AppImage is handed back to the caller, such as refusing to load an
+// AppImage that fails signature verification
+type Options struct {
+	VerifyOnLoad bool     // Refuse to load the AppImage if Verify() fails
+	Keyring      []string // Trusted keys passed to Verify() when VerifyOnLoad is set
+	Identity     *[32]byte // X25519 private key used to decrypt a type 3 (encrypted) AppImage
+}
+
+// NewAppImageWithOptions behaves like NewAppImage but applies `opts`,
+// including decrypting the AppImage at mount time if `opts.Identity` is
+// set, and refusing to hand back an AppImage that fails those checks
+func NewAppImageWithOptions(src string, opts Options) (*AppImage, error) {
+	ai, err := newAppImage(src, opts.Identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.VerifyOnLoad {
+		if err := ai.Verify(opts.Keyring); err != nil {
+			ai.Close()
+			return nil, err
+		}
+	}
+
+	return ai, nil
 }
 
 // Return a reader for the `.DirIcon` file of the AppImage, converting it to
@@ -126,11 +274,51 @@ func (ai AppImage) MountDir() string {
 	return ai.mountDir
 }
 
+// Checksum returns the content digest of `subpath` inside the AppImage's
+// mount, computed once per underlying file and cached across every
+// `AppImage` opened for it
+func (ai AppImage) Checksum(subpath string) (digest.Digest, error) {
+	if ai.cache == nil {
+		return "", errors.New("AppImage has no cache context")
+	}
+
+	return ai.cache.Checksum(subpath)
+}
+
+// Close releases this AppImage's hold on its mount. Once every `AppImage`
+// sharing a mount (via the content cache) has been closed, the underlying
+// SquashFS is unmounted and its temp dirs removed. The unmount itself is
+// owned by the cache context (it was registered by whichever AppImage
+// actually mounted it), not by this particular instance, so it still
+// happens even if this isn't the AppImage that did the mounting
+func (ai AppImage) Close() error {
+	if ai.cache != nil {
+		ai.cache.Release(ai.cacheKey)
+	} else if ai.rmMountDir {
+		exec.Command("fusermount", "-uz", ai.mountDir).Run()
+	}
+
+	return os.RemoveAll(ai.tempDir)
+}
+
 func (ai AppImage) RunId() string {
 	return ai.runId
 }
 
-func (ai AppImage) AddFiles(s []string) {
+// BwrapPid returns the PID of the running `bwrap` child, or 0 if the
+// AppImage hasn't been launched into a sandbox yet
+func (ai AppImage) BwrapPid() int {
+	return ai.bwrapPid
+}
+
+// SetBwrapPid records the PID of the `bwrap` child once the sandbox has
+// been launched. Called by the code that execs bwrap; needed so packages
+// like `checkpoint` can freeze/restore the running sandbox by pid
+func (ai *AppImage) SetBwrapPid(pid int) {
+	ai.bwrapPid = pid
+}
+
+func (ai *AppImage) AddFiles(s []string) {
 	// Add `:ro` if the file doesn't specify
 	for i := range(s) {
 		// Get the last 3 chars of the file entry
@@ -140,11 +328,69 @@ func (ai AppImage) AddFiles(s []string) {
 		ex != ":ro" && ex != ":rw" {
 			s[i] = s[i]+":ro"
 		}
+
+		// Grow the active uid range so this host file's owner is actually
+		// covered by the sandbox's uid_map, rather than being left to fall
+		// back to an arbitrary sandbox uid once bind-mounted. Owners below
+		// the range's HostStart stay unmapped (see UidRange.Grow)
+		if ai.uidRange.Count > 0 {
+			hostPath := strings.SplitN(s[i], ":", 2)[0]
+
+			if fi, err := os.Stat(hostPath); err == nil {
+				if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+					ai.uidRange = ai.uidRange.Grow(int(st.Uid))
+				}
+			}
+		}
 	}
 
 	ai.Perms.Files = append(ai.Perms.Files, s...)
 }
 
+// sandboxUid returns the uid a bind-mounted host file should appear owned by
+// inside the sandbox, translating through the active UidRange so it lines
+// up with the `/proc/<pid>/uid_map` UidMapLines produces for the same range
+func (ai AppImage) sandboxUid(hostUid int) int {
+	if mapped, ok := ai.uidRange.Map(hostUid); ok {
+		return mapped
+	}
+
+	return hostUid
+}
+
+// UidMapLines returns the `/proc/<pid>/uid_map` (and, identically,
+// `gid_map`) lines that put the active UidRange into effect. bwrap doesn't
+// write these itself: launched with `--unshare-user --userns-block-fd <fd>`
+// it creates the user namespace then blocks, giving the launcher a window
+// to write these lines to the child's `uid_map`/`gid_map` before closing
+// `fd` to let it continue into the sandboxed process. Returns nil if no
+// range is active (SetUidRange/SetLevel haven't set one up)
+func (ai AppImage) UidMapLines() []string {
+	if ai.uidRange.Count == 0 {
+		return nil
+	}
+
+	return []string{
+		fmt.Sprintf("%d %d %d", ai.uidRange.SandboxStart, ai.uidRange.HostStart, ai.uidRange.Count),
+	}
+}
+
+// BwrapUserNamespaceArgs returns the bwrap flags that pair with
+// UidMapLines: `--unshare-user` to request a fresh user namespace and
+// `--userns-block-fd` so bwrap pauses after creating it until the launcher
+// writes UidMapLines() to the child's uid/gid maps. There is no code in
+// this tree yet that execs bwrap to pass these to -- SetBwrapPid is set by
+// that future launcher -- but this is the piece it needs to put UidRange
+// into effect rather than bwrap's own single-uid `--uid`/`--gid` flags.
+// Returns nil if no range is active
+func (ai AppImage) BwrapUserNamespaceArgs(blockFd int) []string {
+	if ai.uidRange.Count == 0 {
+		return nil
+	}
+
+	return []string{"--unshare-user", "--userns-block-fd", strconv.Itoa(blockFd)}
+}
+
 func (ai AppImage) AddDevices(s []string) {
 	ai.Perms.Devices = append(ai.Perms.Devices, s...)
 }
@@ -176,10 +422,33 @@ func (ai AppImage) SetTempDir(d string) {
 	tempDir = d
 }
 
-func (ai AppImage) SetLevel(l int) error {
-	err = updateHome(l)
+// UidRange returns the host->sandbox uid/gid mapping currently active for
+// this AppImage's user namespace
+func (ai AppImage) UidRange() permissions.UidRange {
+	return ai.uidRange
+}
 
-	if err != nil {
+// SetUidRange overrides the default host->sandbox uid/gid mapping used to
+// build the bwrap user namespace. hostStart/sandboxStart mark the beginning
+// of a `count`-wide block; callers needing a subordinate id space for nested
+// tools inside the AppImage (fakeroot, chroot helpers) should pass a wider
+// count than the single-uid default
+func (ai *AppImage) SetUidRange(hostStart, sandboxStart, count int) {
+	ai.uidRange = permissions.UidRange{
+		HostStart:    hostStart,
+		SandboxStart: sandboxStart,
+		Count:        count,
+	}
+}
+
+func (ai *AppImage) SetLevel(l int) error {
+	// Levels 2/3 get a blank range mapping just the invoking uid to the
+	// sandbox's fake "ai" user unless the caller already set one explicitly
+	if (l == 2 || l == 3) && ai.uidRange.Count == 0 {
+		ai.uidRange = permissions.Blank(os.Getuid(), 256)
+	}
+
+	if err := ai.updateHome(l); err != nil {
 		return err
 	}
 
@@ -188,19 +457,18 @@ func (ai AppImage) SetLevel(l int) error {
 	return nil
 }
 
-func updateHome(l int) error {
+func (ai *AppImage) updateHome(l int) error {
 	if l == 1 || l == 0 {
 		usr, _ := user.Current()
-//		uid     = strconv.Itoa(os.Getuid())
-		usern   = usr.Username
+		usern = usr.Username
+		uid   = strconv.Itoa(os.Getuid())
 	} else if l > 1 && l <= 3 {
-//		uid   = "256"
 		usern = "ai"
+		uid   = strconv.Itoa(ai.sandboxUid(os.Getuid()))
 	} else {
 		return errors.New("permissions level must be int from 0-3")
 	}
 	homed = filepath.Join("/home", usern)
-	uid   = strconv.Itoa(os.Getuid())
 
 	return nil
 }
@@ -210,35 +478,6 @@ func (ai AppImage) Type() int {
 	return t
 }
 
-// TODO: preserve file permissions
-func (ai AppImage) ExtractFile(path string, dest string, resolveSymlinks bool) error {
-	path = filepath.Join(ai.MountDir(), path)
-
-	// Remove file if it already exists
-	os.Remove(filepath.Join(dest))
-	info, err := os.Lstat(path)
-
-	// True if file is symlink and `resolveSymlinks` is false
-	if info != nil && !resolveSymlinks &&
-	info.Mode()&os.ModeSymlink == os.ModeSymlink {
-		target, _ := os.Readlink(path)
-		err = os.Symlink(target, dest)
-	} else {
-		inF, err := os.Open(path)
-		defer inF.Close()
-		if err != nil { return err }
-
-		outF, err := os.Create(dest)
-		defer outF.Close()
-		if err != nil { return err }
-
-		_, err = io.Copy(outF, inF)
-		if err != nil { return err }
-	}
-
-	return err
-}
-
 func (ai AppImage) Icon() (io.ReadCloser, string, error) {
 	if ai.Desktop == nil {
 		return nil, "", errors.New("desktop file wasn't parsed")
@@ -0,0 +1,58 @@
+package aisap
+
+import "testing"
+
+func TestUidMapLinesEmptyWithNoRange(t *testing.T) {
+	ai := &AppImage{}
+
+	if lines := ai.UidMapLines(); lines != nil {
+		t.Fatalf("expected no uid_map lines with no active range, got %v", lines)
+	}
+
+	if args := ai.BwrapUserNamespaceArgs(3); args != nil {
+		t.Fatalf("expected no bwrap args with no active range, got %v", args)
+	}
+}
+
+func TestUidMapLinesReflectsActiveRange(t *testing.T) {
+	ai := &AppImage{}
+	ai.SetUidRange(1000, 256, 1)
+
+	lines := ai.UidMapLines()
+	if len(lines) != 1 || lines[0] != "256 1000 1" {
+		t.Fatalf("UidMapLines = %v, want [\"256 1000 1\"]", lines)
+	}
+}
+
+func TestBwrapUserNamespaceArgsIncludesBlockFd(t *testing.T) {
+	ai := &AppImage{}
+	ai.SetUidRange(1000, 256, 1)
+
+	args := ai.BwrapUserNamespaceArgs(7)
+	want := []string{"--unshare-user", "--userns-block-fd", "7"}
+
+	if len(args) != len(want) {
+		t.Fatalf("BwrapUserNamespaceArgs = %v, want %v", args, want)
+	}
+	for i := range want {
+		if args[i] != want[i] {
+			t.Fatalf("BwrapUserNamespaceArgs = %v, want %v", args, want)
+		}
+	}
+}
+
+func TestSandboxUidMapsThroughActiveRange(t *testing.T) {
+	ai := &AppImage{}
+	ai.SetUidRange(1000, 256, 1)
+	ai.uidRange = ai.uidRange.Grow(1002)
+
+	if got := ai.sandboxUid(1000); got != 256 {
+		t.Fatalf("sandboxUid(1000) = %d, want 256", got)
+	}
+	if got := ai.sandboxUid(1002); got != 258 {
+		t.Fatalf("sandboxUid(1002) = %d, want 258", got)
+	}
+	if got := ai.sandboxUid(9999); got != 9999 {
+		t.Fatalf("sandboxUid(9999) outside the range should pass through unmapped, got %d", got)
+	}
+}
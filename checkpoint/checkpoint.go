@@ -0,0 +1,193 @@
+// Package checkpoint freezes and restores a running sandboxed AppImage
+// using CRIU, so a long-lived sandbox (a game, a build daemon) can be
+// suspended to disk and later resumed without restarting from scratch
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	criu "github.com/checkpoint-restore/go-criu/v6"
+	rpc "github.com/checkpoint-restore/go-criu/v6/rpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mgord9518/aisap"
+	"github.com/mgord9518/aisap/permissions"
+)
+
+// ErrDigestMismatch is returned by Restore when the AppImage on disk has
+// changed since the checkpoint was taken
+var ErrDigestMismatch = errors.New("checkpoint: AppImage content digest doesn't match checkpoint manifest")
+
+// CheckpointOpts controls how a running sandbox is frozen
+type CheckpointOpts struct {
+	LeaveRunning    bool // Don't stop the process tree after dumping it
+	TcpEstablished  bool // Allow checkpointing open TCP connections
+	ShellJob        bool // The sandboxed process is attached to a controlling terminal
+}
+
+// manifest is the small JSON sidecar written into a checkpoint dir,
+// carrying everything Restore needs to re-mount and re-launch the AppImage
+// before handing the process tree back to CRIU
+type manifest struct {
+	AppImagePath string                     `json:"appimage_path"`
+	Digest       string                     `json:"digest"`
+	Offset       int                        `json:"offset"`
+	Perms        *permissions.AppImagePerms `json:"perms"`
+	UidRange     permissions.UidRange       `json:"uid_range"`
+	MountDir     string                     `json:"mount_dir"`
+	TempDir      string                     `json:"temp_dir"`
+	Binds        []string                   `json:"binds"`
+}
+
+// Checkpoint freezes `ai`'s running bwrap process tree, dumping it to `dir`
+// alongside a manifest describing how to re-mount and restore it later
+func Checkpoint(ai *aisap.AppImage, dir string, opts CheckpointOpts) error {
+	if ai.BwrapPid() == 0 {
+		return errors.New("checkpoint: AppImage has no running bwrap child")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	imgDir, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer imgDir.Close()
+
+	digest, err := ai.Checksum("/")
+	if err != nil {
+		return fmt.Errorf("checkpoint: hashing AppImage: %w", err)
+	}
+
+	m := manifest{
+		AppImagePath: ai.Path,
+		Digest:       digest.String(),
+		Offset:       ai.Offset,
+		Perms:        ai.Perms,
+		UidRange:     ai.UidRange(),
+		MountDir:     ai.MountDir(),
+		TempDir:      ai.TempDir(),
+		Binds:        ai.Perms.Files,
+	}
+
+	if err := writeManifest(dir, &m); err != nil {
+		return err
+	}
+
+	c := criu.MakeCriu()
+
+	external := []string{
+		"mnt[" + ai.MountDir() + "]:" + ai.MountDir(),
+		"mnt[" + ai.TempDir() + "]:" + ai.TempDir(),
+	}
+	for _, b := range ai.Perms.Files {
+		path := strings.SplitN(b, ":", 2)[0]
+		external = append(external, "mnt["+path+"]:"+path)
+	}
+
+	req := &rpc.CriuOpts{
+		Pid:            proto.Int32(int32(ai.BwrapPid())),
+		ImagesDirFd:    proto.Int32(int32(imgDir.Fd())),
+		LeaveRunning:   proto.Bool(opts.LeaveRunning),
+		TcpEstablished: proto.Bool(opts.TcpEstablished),
+		ShellJob:       proto.Bool(opts.ShellJob),
+		External:       external,
+	}
+
+	return c.Dump(req, criu.NoNotify{})
+}
+
+// Restore re-mounts the AppImage described by the manifest in `dir` at the
+// exact mount/temp paths recorded at checkpoint time (CRIU's external mount
+// descriptors were bound to those specific paths at dump time), verifies
+// its content digest still matches, then resumes it with CRIU
+func Restore(dir string) (*aisap.AppImage, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(m.AppImagePath); err != nil {
+		return nil, err
+	}
+
+	ai, err := aisap.NewAppImageAt(m.AppImagePath, m.TempDir, m.MountDir, m.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := ai.Checksum("/")
+	if err != nil {
+		return nil, err
+	}
+
+	if digest.String() != m.Digest {
+		return nil, ErrDigestMismatch
+	}
+
+	ai.SetUidRange(m.UidRange.HostStart, m.UidRange.SandboxStart, m.UidRange.Count)
+
+	imgDir, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer imgDir.Close()
+
+	c := criu.MakeCriu()
+
+	// NewAppImageAt remounted at the exact paths recorded in the manifest,
+	// so each external resolves to the same path it was dumped with; still
+	// pass the mapping explicitly rather than relying on that coincidence
+	extMnt := []*rpc.ExtMountMap{
+		{Key: proto.String(m.MountDir), Val: proto.String(ai.MountDir())},
+		{Key: proto.String(m.TempDir), Val: proto.String(ai.TempDir())},
+	}
+	for _, b := range m.Binds {
+		path := strings.SplitN(b, ":", 2)[0]
+		extMnt = append(extMnt, &rpc.ExtMountMap{Key: proto.String(path), Val: proto.String(path)})
+	}
+
+	req := &rpc.CriuOpts{
+		ImagesDirFd: proto.Int32(int32(imgDir.Fd())),
+		ExtMnt:      extMnt,
+	}
+
+	if err := c.Restore(req, criu.NoNotify{}); err != nil {
+		return nil, err
+	}
+
+	return ai, nil
+}
+
+func writeManifest(dir string, m *manifest) error {
+	f, err := os.Create(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	return enc.Encode(m)
+}
+
+func readManifest(dir string) (*manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
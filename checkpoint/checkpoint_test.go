@@ -0,0 +1,61 @@
+package checkpoint
+
+import (
+	"testing"
+
+	"github.com/mgord9518/aisap/permissions"
+)
+
+func TestManifestRoundTrips(t *testing.T) {
+	dir := t.TempDir()
+
+	want := &manifest{
+		AppImagePath: "/tmp/foo.AppImage",
+		Digest:       "sha256:deadbeef",
+		Offset:       12345,
+		Perms:        &permissions.AppImagePerms{Level: 2},
+		UidRange:     permissions.Blank(1000, 256),
+		MountDir:     "/tmp/.mount_abc",
+		TempDir:      "/tmp/.aisapTemp_abc",
+		Binds:        []string{"/home/user/foo:/foo"},
+	}
+
+	if err := writeManifest(dir, want); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.AppImagePath != want.AppImagePath || got.Digest != want.Digest ||
+		got.Offset != want.Offset || got.MountDir != want.MountDir ||
+		got.TempDir != want.TempDir {
+		t.Fatalf("manifest round trip mismatch: got %+v, want %+v", got, want)
+	}
+
+	if got.UidRange != want.UidRange {
+		t.Fatalf("UidRange round trip mismatch: got %+v, want %+v", got.UidRange, want.UidRange)
+	}
+
+	if len(got.Binds) != 1 || got.Binds[0] != want.Binds[0] {
+		t.Fatalf("Binds round trip mismatch: got %v, want %v", got.Binds, want.Binds)
+	}
+}
+
+func TestRestoreErrorsWhenAppImageMissing(t *testing.T) {
+	dir := t.TempDir()
+
+	m := &manifest{
+		AppImagePath: "/nonexistent/does-not-exist.AppImage",
+		UidRange:     permissions.Blank(1000, 256),
+	}
+	if err := writeManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Restore(dir); err == nil {
+		t.Fatalf("expected Restore to fail when the checkpointed AppImage no longer exists")
+	}
+}
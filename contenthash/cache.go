@@ -0,0 +1,140 @@
+package contenthash
+
+import (
+	"sync"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// CacheContext lazily builds and caches the digest tree for one mounted
+// AppImage, and refcounts how many callers are relying on its mount
+// surviving so it isn't torn down out from under a still-active lookup.
+// It (not whichever caller happens to make the last Release call) owns the
+// decision of how to actually tear the mount down
+type CacheContext struct {
+	mu       sync.Mutex
+	root     string
+	tree     *tree
+	refCount int
+	onEvict  func() // Runs once, when the last reference is released
+}
+
+// Root returns the mount directory this cache context was built for
+func (c *CacheContext) Root() string {
+	return c.root
+}
+
+// Checksum returns the digest of `subpath` (relative to the AppImage's
+// mount root), building the tree on first use
+func (c *CacheContext) Checksum(subpath string) (digest.Digest, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tree == nil {
+		t, err := build(c.root)
+		if err != nil {
+			return "", err
+		}
+
+		c.tree = t
+	}
+
+	return c.tree.checksum(subpath)
+}
+
+// retain increments the refcount; it must be balanced with Release
+func (c *CacheContext) retain() {
+	c.mu.Lock()
+	c.refCount++
+	c.mu.Unlock()
+}
+
+// Release drops a reference. Once the last one is released, the context is
+// evicted from the manager and its registered onEvict callback (typically
+// the actual unmount) runs exactly once, regardless of which caller's
+// Release happened to be the one that dropped the count to zero
+func (c *CacheContext) Release(key string) (last bool) {
+	c.mu.Lock()
+	c.refCount--
+	last = c.refCount <= 0
+	onEvict := c.onEvict
+	c.mu.Unlock()
+
+	if last {
+		defaultManager.evict(key)
+
+		if onEvict != nil {
+			onEvict()
+		}
+	}
+
+	return last
+}
+
+type manager struct {
+	mu       sync.Mutex
+	contexts map[string]*CacheContext
+}
+
+var defaultManager = &manager{contexts: map[string]*CacheContext{}}
+
+// Acquire looks up an already-mounted AppImage's cache context by `key`
+// (conventionally its path plus mtime), retaining a reference on hit
+func Acquire(key string) (cc *CacheContext, hit bool) {
+	defaultManager.mu.Lock()
+	cc, hit = defaultManager.contexts[key]
+	defaultManager.mu.Unlock()
+
+	if hit {
+		cc.retain()
+	}
+
+	return cc, hit
+}
+
+// Register creates a new cache context for a freshly mounted AppImage,
+// keyed by `key` and rooted at `root` (its mount directory), with an
+// initial refcount of one. `onEvict`, if non-nil, runs exactly once, when
+// the context's last reference is released (the natural place for the
+// caller that did the mounting to undo it)
+func Register(key, root string, onEvict func()) *CacheContext {
+	cc := &CacheContext{root: root, refCount: 1, onEvict: onEvict}
+
+	defaultManager.mu.Lock()
+	defaultManager.contexts[key] = cc
+	defaultManager.mu.Unlock()
+
+	return cc
+}
+
+func (m *manager) evict(key string) {
+	m.mu.Lock()
+	delete(m.contexts, key)
+	m.mu.Unlock()
+}
+
+// treeCache backs the package-level Checksum convenience function below. It
+// intentionally doesn't go through the refcounted manager: a one-off caller
+// has no mount to release ownership of, so reusing CacheContext's refcount
+// here would just mean every call evicts what it (or Acquire) just created
+var treeCache sync.Map // key string -> *tree
+
+// Checksum computes (or reuses, for a previously seen `key`) the digest
+// tree rooted at `root` and returns the digest of `subpath`. For callers
+// that already manage a mount's lifecycle via Acquire/Register, use the
+// returned CacheContext's Checksum method instead so lookups share its
+// refcounted tree
+func Checksum(key, root, subpath string) (digest.Digest, error) {
+	if v, ok := treeCache.Load(key); ok {
+		return v.(*tree).checksum(subpath)
+	}
+
+	t, err := build(root)
+	if err != nil {
+		return "", err
+	}
+
+	treeCache.Store(key, t)
+
+	return t.checksum(subpath)
+}
@@ -0,0 +1,179 @@
+// Package contenthash computes a stable digest of a mounted AppImage's
+// SquashFS tree, mirroring BuildKit's contenthash package. Digests are kept
+// in an immutable, path-indexed tree so that repeated lookups against the
+// same mount (or unmodified sibling AppImage) never re-walk the filesystem
+package contenthash
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+// tree stores two digests per directory: "/dir/" for the header (mode, uid,
+// gid) and "/dir" for the recursive content digest of its children,
+// combined in sorted order. Files and symlinks only ever get the "/path"
+// entry, combining their header with their content (or link target)
+type tree struct {
+	root    string
+	entries map[string]digest.Digest
+}
+
+// build walks `root` bottom-up, populating a tree of per-path digests
+func build(root string) (*tree, error) {
+	t := &tree{root: root, entries: map[string]digest.Digest{}}
+
+	// dirChildren accumulates the combined "/path" digest of each
+	// directory's immediate children, keyed by the parent's cleaned path
+	dirChildren := map[string][]string{}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		fi, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		rel := "/" + strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+		rel = filepath.ToSlash(filepath.Clean(rel))
+
+		header := headerDigest(fi)
+
+		switch {
+		case fi.IsDir():
+			t.entries[dirHeaderKey(rel)] = header
+
+			names, err := readdirnames(path)
+			if err != nil {
+				return err
+			}
+
+			for _, name := range names {
+				if err := walk(filepath.Join(path, name)); err != nil {
+					return err
+				}
+			}
+
+			t.entries[rel] = combine(header, childDigests(t, dirChildren[rel]))
+		case fi.Mode()&os.ModeSymlink == os.ModeSymlink:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			t.entries[rel] = combine(header, digest.FromString(target))
+		default:
+			d, err := fileDigest(path)
+			if err != nil {
+				return err
+			}
+
+			t.entries[rel] = combine(header, d)
+		}
+
+		parent := filepath.ToSlash(filepath.Clean("/" + strings.TrimPrefix(strings.TrimPrefix(filepath.Dir(path), root), "/")))
+		dirChildren[parent] = append(dirChildren[parent], rel)
+
+		return nil
+	}
+
+	if err := walk(root); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// checksum returns the digest stored for `subpath`, which must already have
+// been populated by build()
+func (t *tree) checksum(subpath string) (digest.Digest, error) {
+	clean := filepath.ToSlash(filepath.Clean("/" + subpath))
+
+	d, ok := t.entries[clean]
+	if !ok {
+		return "", fmt.Errorf("contenthash: no entry for %q", subpath)
+	}
+
+	return d, nil
+}
+
+func dirHeaderKey(cleanPath string) string {
+	if cleanPath == "/" {
+		return "/"
+	}
+
+	return cleanPath + "/"
+}
+
+// childDigests combines a directory's already-computed child digests in
+// sorted path order, giving a stable digest regardless of readdir order
+func childDigests(t *tree, children []string) digest.Digest {
+	sorted := append([]string{}, children...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	for _, c := range sorted {
+		io.WriteString(h, c)
+		io.WriteString(h, "\x00")
+		io.WriteString(h, t.entries[c].String())
+		io.WriteString(h, "\x00")
+	}
+
+	return digest.NewDigestFromBytes(digest.SHA256, h.Sum(nil))
+}
+
+// headerDigest hashes the metadata of a file that should invalidate its
+// content digest if changed: mode, uid and gid
+func headerDigest(fi os.FileInfo) digest.Digest {
+	h := sha256.New()
+	fmt.Fprintf(h, "%o\x00%d\x00%d\x00", fi.Mode(), uidOf(fi), gidOf(fi))
+
+	return digest.NewDigestFromBytes(digest.SHA256, h.Sum(nil))
+}
+
+// fileDigest hashes a regular file's contents
+func fileDigest(path string) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return digest.NewDigestFromBytes(digest.SHA256, h.Sum(nil)), nil
+}
+
+func combine(header, content digest.Digest) digest.Digest {
+	h := sha256.New()
+	io.WriteString(h, header.String())
+	io.WriteString(h, "\x00")
+	io.WriteString(h, content.String())
+
+	return digest.NewDigestFromBytes(digest.SHA256, h.Sum(nil))
+}
+
+func readdirnames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,159 @@
+package contenthash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildIsStableAcrossReaddirOrder(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root)
+
+	t1, err := build(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t2, err := build(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d1, err := t1.checksum("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d2, err := t2.checksum("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d1 != d2 {
+		t.Fatalf("root digest not stable: %s != %s", d1, d2)
+	}
+}
+
+func TestBuildChangesWhenContentChanges(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root)
+
+	before, err := build(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeDigest, err := before.checksum("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := build(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterDigest, err := after.checksum("/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if beforeDigest == afterDigest {
+		t.Fatalf("digest should change when file content changes")
+	}
+}
+
+func TestChecksumMissingPathErrors(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root)
+
+	tr, err := build(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tr.checksum("/nope.txt"); err == nil {
+		t.Fatalf("expected an error for a path not present in the tree")
+	}
+}
+
+func TestCacheContextReleaseRunsOnEvictOnlyOnLastReference(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root)
+
+	evicted := 0
+	cc := Register("test-key-refcount", root, func() { evicted++ })
+
+	// A second user acquires a reference to the same context
+	second, hit := Acquire("test-key-refcount")
+	if !hit {
+		t.Fatalf("expected Acquire to hit the just-registered context")
+	}
+	if second != cc {
+		t.Fatalf("Acquire should return the same CacheContext instance")
+	}
+
+	if last := cc.Release("test-key-refcount"); last {
+		t.Fatalf("Release should not report last with an outstanding reference")
+	}
+	if evicted != 0 {
+		t.Fatalf("onEvict should not run while a reference is outstanding")
+	}
+
+	if last := second.Release("test-key-refcount"); !last {
+		t.Fatalf("Release should report last once the final reference drops")
+	}
+	if evicted != 1 {
+		t.Fatalf("onEvict should run exactly once, got %d", evicted)
+	}
+
+	if _, hit := Acquire("test-key-refcount"); hit {
+		t.Fatalf("context should be evicted from the manager after the last release")
+	}
+}
+
+func TestPackageChecksumReusesTreeAcrossCalls(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root)
+
+	key := "test-key-package-checksum"
+
+	d1, err := Checksum(key, root, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the file on disk; a cached tree should still return the old
+	// digest for this key, proving the tree was reused rather than rebuilt
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d2, err := Checksum(key, root, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d1 != d2 {
+		t.Fatalf("Checksum should reuse the cached tree for an unchanged key: %s != %s", d1, d2)
+	}
+}
@@ -0,0 +1,22 @@
+package contenthash
+
+import (
+	"os"
+	"syscall"
+)
+
+func uidOf(fi os.FileInfo) uint32 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid
+	}
+
+	return 0
+}
+
+func gidOf(fi os.FileInfo) uint32 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Gid
+	}
+
+	return 0
+}
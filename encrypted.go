@@ -0,0 +1,170 @@
+package aisap
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/sys/unix"
+
+	helpers "github.com/mgord9518/aisap/helpers"
+)
+
+// encryptedHeaderSize is the length of the per-file AES-GCM nonce written
+// at the offset `GetOffset` returns for a type 3 (encrypted) AppImage,
+// immediately before the wrapped SquashFS ciphertext
+const encryptedHeaderSize = 12
+
+// decryptToMemfd decrypts a type 3 AppImage's AES-GCM wrapped SquashFS
+// (whose ciphertext starts at `offset`) into an anonymous memfd, unwrapping
+// the data key for `identity` (an X25519 private key) from the trailer's
+// recipient list, and returns `/proc/self/fd/N` ready to hand to squashfuse
+func decryptToMemfd(src string, offset int, identity [32]byte) (string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	nonce := make([]byte, encryptedHeaderSize)
+	if _, err := f.ReadAt(nonce, int64(offset)); err != nil {
+		return "", err
+	}
+
+	dataKey, err := unwrapDataKey(src, identity)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(dataKey[:])
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	// The recipient list sits in a zip trailer appended after the
+	// ciphertext, so the ciphertext ends where that trailer begins
+	body, err := helpers.ReadUntilTrailer(src)
+	if err != nil {
+		return "", err
+	}
+	if len(body) < offset+encryptedHeaderSize {
+		return "", errors.New("decrypt AppImage: payload is shorter than its nonce")
+	}
+	ciphertext := body[offset+encryptedHeaderSize:]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypt AppImage: %w", err)
+	}
+
+	fd, err := unix.MemfdCreate(".aisap-decrypted", 0)
+	if err != nil {
+		return "", err
+	}
+
+	if err := unix.Ftruncate(fd, int64(len(plaintext))); err != nil {
+		unix.Close(fd)
+		return "", err
+	}
+
+	if _, err := unix.Pwrite(fd, plaintext, 0); err != nil {
+		unix.Close(fd)
+		return "", err
+	}
+
+	return fmt.Sprintf("/proc/self/fd/%d", fd), nil
+}
+
+// unwrapDataKey scans the trailer's `.recipients` list for an entry
+// matching `identity`'s public key and decrypts the data key sealed there.
+// Each line is "<recipient pubkey b64>:<sender ephemeral pubkey b64>:
+// <nonce b64>:<wrapped key b64>", where the wrapping AES-GCM key is
+// SHA-256(X25519(identity, ephemeral pubkey))
+func unwrapDataKey(src string, identity [32]byte) ([32]byte, error) {
+	var dataKey [32]byte
+
+	ourPub, err := curve25519.X25519(identity[:], curve25519.Basepoint)
+	if err != nil {
+		return dataKey, err
+	}
+
+	raw, err := helpers.FindRecipients(src)
+	if err != nil {
+		return dataKey, fmt.Errorf("decrypt AppImage: %w", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		key, ok := unwrapDataKeyFromLine(line, identity, ourPub)
+		if ok {
+			return key, nil
+		}
+	}
+
+	return dataKey, errors.New("decrypt AppImage: no recipient entry found for this identity")
+}
+
+func unwrapDataKeyFromLine(line string, identity [32]byte, ourPub []byte) ([32]byte, bool) {
+	var dataKey [32]byte
+
+	fields := strings.Split(strings.TrimSpace(line), ":")
+	if len(fields) != 4 {
+		return dataKey, false
+	}
+
+	recipientPub, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil || !bytes.Equal(recipientPub, ourPub) {
+		return dataKey, false
+	}
+
+	ephPub, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return dataKey, false
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return dataKey, false
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return dataKey, false
+	}
+
+	shared, err := curve25519.X25519(identity[:], ephPub)
+	if err != nil {
+		return dataKey, false
+	}
+
+	wrapKey := sha256.Sum256(shared)
+
+	block, err := aes.NewCipher(wrapKey[:])
+	if err != nil {
+		return dataKey, false
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return dataKey, false
+	}
+
+	plain, err := gcm.Open(nil, nonce, wrapped, nil)
+	if err != nil || len(plain) != len(dataKey) {
+		return dataKey, false
+	}
+
+	copy(dataKey[:], plain)
+	return dataKey, true
+}
@@ -0,0 +1,134 @@
+package aisap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// sealRecipientEntry builds one line of a `.recipients` trailer, wrapping
+// dataKey for recipientPub the same way the real encrypter would: an
+// ephemeral X25519 keypair, a shared secret with the recipient, and
+// AES-GCM sealing the data key under SHA-256 of that shared secret
+func sealRecipientEntry(t *testing.T, recipientPub [32]byte, dataKey [32]byte) string {
+	t.Helper()
+
+	var ephPriv [32]byte
+	if _, err := rand.Read(ephPriv[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	ephPub, err := curve25519.X25519(ephPriv[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared, err := curve25519.X25519(ephPriv[:], recipientPub[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapKey := sha256.Sum256(shared)
+
+	block, err := aes.NewCipher(wrapKey[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped := gcm.Seal(nil, nonce, dataKey[:], nil)
+
+	return fmt.Sprintf(
+		"%s:%s:%s:%s",
+		base64.StdEncoding.EncodeToString(recipientPub[:]),
+		base64.StdEncoding.EncodeToString(ephPub),
+		base64.StdEncoding.EncodeToString(nonce),
+		base64.StdEncoding.EncodeToString(wrapped),
+	)
+}
+
+func TestUnwrapDataKeyFromLineRoundTrips(t *testing.T) {
+	var identity [32]byte
+	if _, err := rand.Read(identity[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	ourPub, err := curve25519.X25519(identity[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wantKey [32]byte
+	if _, err := rand.Read(wantKey[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	var recipientPub [32]byte
+	copy(recipientPub[:], ourPub)
+
+	line := sealRecipientEntry(t, recipientPub, wantKey)
+
+	gotKey, ok := unwrapDataKeyFromLine(line, identity, ourPub)
+	if !ok {
+		t.Fatalf("unwrapDataKeyFromLine failed to unwrap an entry addressed to us")
+	}
+
+	if gotKey != wantKey {
+		t.Fatalf("unwrapped data key doesn't match: got %x, want %x", gotKey, wantKey)
+	}
+}
+
+func TestUnwrapDataKeyFromLineIgnoresOtherRecipients(t *testing.T) {
+	var identity [32]byte
+	if _, err := rand.Read(identity[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	ourPub, err := curve25519.X25519(identity[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var otherPub [32]byte
+	if _, err := rand.Read(otherPub[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	var dataKey [32]byte
+	if _, err := rand.Read(dataKey[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	line := sealRecipientEntry(t, otherPub, dataKey)
+
+	if _, ok := unwrapDataKeyFromLine(line, identity, ourPub); ok {
+		t.Fatalf("unwrapDataKeyFromLine should not unwrap an entry addressed to a different recipient")
+	}
+}
+
+func TestUnwrapDataKeyFromLineRejectsMalformed(t *testing.T) {
+	var identity [32]byte
+	ourPub, err := curve25519.X25519(identity[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := unwrapDataKeyFromLine("not-enough-fields", identity, ourPub); ok {
+		t.Fatalf("expected malformed line to be rejected")
+	}
+}
@@ -0,0 +1,345 @@
+package aisap
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// ExportSpec describes how to pull an AppImage's mounted tree out into
+// another form, modeled on BuildKit's output types
+type ExportSpec struct {
+	Type string // "dir", "tar" or "oci-layer"
+	Dest string // Destination path, or "-" for stdout when Type is "tar"
+
+	Include []string // Glob patterns (matched against mount-relative paths); empty means everything
+	Exclude []string // Glob patterns to drop, applied after Include
+
+	PreservePerms bool // Keep mode/uid/gid/mtime (dir/tar only; oci-layer always preserves them)
+	DerefSymlinks bool // Follow symlinks instead of recreating them
+	Reproducible  bool // Sort entries and zero mtimes (tar/oci-layer only)
+}
+
+// ociDescriptor is the small JSON sidecar written next to an "oci-layer"
+// export, describing the gzip blob it sits alongside
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Export copies files out of the AppImage's mount according to `spec`
+func (ai AppImage) Export(spec ExportSpec) error {
+	paths, err := ai.exportPaths(spec)
+	if err != nil {
+		return err
+	}
+
+	switch spec.Type {
+	case "dir":
+		return exportDir(ai.MountDir(), spec.Dest, paths, spec)
+	case "tar":
+		return exportTar(ai.MountDir(), spec.Dest, paths, spec)
+	case "oci-layer":
+		return exportOciLayer(ai.MountDir(), spec.Dest, paths, spec)
+	default:
+		return errors.New("export: unknown type " + spec.Type)
+	}
+}
+
+// exportPaths walks the mount, returning mount-relative paths that match
+// Include (or everything, if empty) and don't match Exclude, sorted for
+// reproducibility
+func (ai AppImage) exportPaths(spec ExportSpec) ([]string, error) {
+	var paths []string
+
+	err := filepath.Walk(ai.MountDir(), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == ai.MountDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ai.MountDir(), p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if len(spec.Include) > 0 && !matchesAny(rel, spec.Include) {
+			return nil
+		}
+		if matchesAny(rel, spec.Exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func matchesAny(rel string, patterns []string) bool {
+	for _, pat := range patterns {
+		if ok, _ := filepath.Match(pat, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exportDir copies each selected path into `dest`, preserving directory
+// structure and, if requested, mode/uid/gid/mtime
+func exportDir(root, dest string, paths []string, spec ExportSpec) error {
+	for _, rel := range paths {
+		src := filepath.Join(root, rel)
+		dst := filepath.Join(dest, rel)
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+
+		if err := copyEntry(src, dst, spec.PreservePerms, spec.DerefSymlinks); err != nil {
+			return err
+		}
+	}
+
+	// Copying a directory's children bumps its mtime, so directory times
+	// are set again once every entry is in place
+	if spec.PreservePerms {
+		for _, rel := range paths {
+			info, err := os.Lstat(filepath.Join(root, rel))
+			if err != nil || !info.IsDir() {
+				continue
+			}
+
+			if err := os.Chtimes(filepath.Join(dest, rel), info.ModTime(), info.ModTime()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// exportTar streams a POSIX ustar archive of the selected paths to
+// `dest` (or stdout, if "-")
+func exportTar(root, dest string, paths []string, spec ExportSpec) error {
+	var w io.Writer
+	if dest == "-" {
+		w = os.Stdout
+	} else {
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	return writeTar(tw, root, paths, spec)
+}
+
+func writeTar(tw *tar.Writer, root string, paths []string, spec ExportSpec) error {
+	for _, rel := range paths {
+		src := filepath.Join(root, rel)
+
+		var info os.FileInfo
+		var err error
+		if spec.DerefSymlinks {
+			info, err = os.Stat(src)
+		} else {
+			info, err = os.Lstat(src)
+		}
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 && !spec.DerefSymlinks {
+			link, err = os.Readlink(src)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+
+		if !spec.PreservePerms {
+			hdr.Uid, hdr.Gid = 0, 0
+			hdr.Uname, hdr.Gname = "", ""
+		}
+		if spec.Reproducible {
+			hdr.ModTime = time.Unix(0, 0)
+			hdr.AccessTime = time.Time{}
+			hdr.ChangeTime = time.Time{}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// exportOciLayer writes a gzip-compressed tar layer to `dest` plus a JSON
+// descriptor (`<dest>.json`) suitable for pushing into an OCI registry
+func exportOciLayer(root, dest string, paths []string, spec ExportSpec) error {
+	spec.PreservePerms = true
+	spec.Reproducible = true
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	gw := gzip.NewWriter(io.MultiWriter(f, h))
+	tw := tar.NewWriter(gw)
+
+	if err := writeTar(tw, root, paths, spec); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	desc := ociDescriptor{
+		MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+		Digest:    "sha256:" + hex.EncodeToString(h.Sum(nil)),
+		Size:      fi.Size(),
+	}
+
+	descFile, err := os.Create(dest + ".json")
+	if err != nil {
+		return err
+	}
+	defer descFile.Close()
+
+	enc := json.NewEncoder(descFile)
+	enc.SetIndent("", "\t")
+	return enc.Encode(desc)
+}
+
+// copyEntry copies a single file, directory or symlink from src to dst,
+// optionally preserving its mode/uid/gid/mtime and following symlinks
+func copyEntry(src, dst string, preservePerms, derefSymlinks bool) error {
+	var info os.FileInfo
+	var err error
+	if derefSymlinks {
+		info, err = os.Stat(src)
+	} else {
+		info, err = os.Lstat(src)
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+
+		if !preservePerms {
+			return nil
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			os.Chown(dst, int(st.Uid), int(st.Gid))
+		}
+
+		return os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 && !derefSymlinks {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+
+		os.Remove(dst)
+		return os.Symlink(target, dst)
+	}
+
+	inF, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer inF.Close()
+
+	os.Remove(dst)
+	outF, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer outF.Close()
+
+	if _, err := io.Copy(outF, inF); err != nil {
+		return err
+	}
+
+	if !preservePerms {
+		return nil
+	}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		os.Chown(dst, int(st.Uid), int(st.Gid))
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// ExtractFile copies a single path out of the AppImage's mount, preserving
+// permissions. It's a thin wrapper around Export's copy logic kept for
+// callers that only need one file
+func (ai AppImage) ExtractFile(path string, dest string, resolveSymlinks bool) error {
+	return copyEntry(filepath.Join(ai.MountDir(), path), dest, true, resolveSymlinks)
+}
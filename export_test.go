@@ -0,0 +1,137 @@
+package aisap
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchesAny(t *testing.T) {
+	cases := []struct {
+		rel      string
+		patterns []string
+		want     bool
+	}{
+		{"usr/bin/foo", []string{"usr/bin/*"}, true},
+		{"usr/lib/foo.so", []string{"usr/bin/*"}, false},
+		{"foo.txt", nil, false},
+		{"foo.txt", []string{"*.txt", "*.md"}, true},
+	}
+
+	for _, c := range cases {
+		if got := matchesAny(c.rel, c.patterns); got != c.want {
+			t.Errorf("matchesAny(%q, %v) = %v, want %v", c.rel, c.patterns, got, c.want)
+		}
+	}
+}
+
+func TestExportPathsFiltersByIncludeAndExclude(t *testing.T) {
+	root := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(root, "usr", "bin", "foo"), "bin")
+	mustWriteFile(t, filepath.Join(root, "usr", "lib", "foo.so"), "lib")
+	mustWriteFile(t, filepath.Join(root, "README.md"), "docs")
+
+	ai := AppImage{mountDir: root}
+
+	paths, err := ai.exportPaths(ExportSpec{
+		Include: []string{"usr/bin/*", "usr/lib/*", "README.md"},
+		Exclude: []string{"usr/lib/*.so"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"README.md", filepath.ToSlash(filepath.Join("usr", "bin", "foo"))}
+	if len(paths) != len(want) {
+		t.Fatalf("exportPaths = %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("exportPaths = %v, want %v", paths, want)
+		}
+	}
+}
+
+func mustWriteFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteTarKeepsSymlinksByDefault(t *testing.T) {
+	root := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(root, "real.txt"), "hello")
+	if err := os.Symlink("real.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := writeTar(tw, root, []string{"real.txt", "link.txt"}, ExportSpec{}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	hdrs := map[string]*tar.Header{}
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		hdrs[hdr.Name] = hdr
+	}
+
+	link, ok := hdrs["link.txt"]
+	if !ok {
+		t.Fatalf("expected an entry for link.txt")
+	}
+	if link.Typeflag != tar.TypeSymlink || link.Linkname != "real.txt" {
+		t.Fatalf("link.txt should be a symlink to real.txt, got typeflag=%v linkname=%q", link.Typeflag, link.Linkname)
+	}
+}
+
+func TestWriteTarDereferencesSymlinksWhenRequested(t *testing.T) {
+	root := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(root, "real.txt"), "hello")
+	if err := os.Symlink("real.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	spec := ExportSpec{DerefSymlinks: true}
+	if err := writeTar(tw, root, []string{"link.txt"}, spec); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hdr.Typeflag != tar.TypeReg {
+		t.Fatalf("dereferenced link.txt should be a regular file entry, got typeflag=%v", hdr.Typeflag)
+	}
+
+	content := make([]byte, hdr.Size)
+	if _, err := io.ReadFull(tr, content); err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello" {
+		t.Fatalf("dereferenced link.txt content = %q, want %q", content, "hello")
+	}
+}
@@ -21,7 +21,7 @@ func GetOffset(src string) (int, error) {
 
 	if format == -2 {
 		return getShappImageSize(src)
-	} else if format == 2 {
+	} else if format == 2 || format == 3 {
 		return getElfSize(src)
 	} else if format == 0 {
 		return -1, errors.New("AppImage missing `AI\\0x02` magic at offset 0x08!")
@@ -117,8 +117,8 @@ func getElfSize(src string) (int, error) {
 
 // Find the type of AppImage
 // Returns strings either `1` for ISO disk image AppImage, `2` for type 2
-// SquashFS AppImage, `0` for unknown valid ELF or `-2` for shell script
-// SquashFS AppImage (shappimage)
+// SquashFS AppImage, `3` for an AES-GCM encrypted SquashFS AppImage, `0` for
+// unknown valid ELF or `-2` for shell script SquashFS AppImage (shappimage)
 func GetAppImageType(src string) (int, error) {
 	f, err := os.Open(src)
 	defer f.Close()
@@ -138,6 +138,9 @@ func GetAppImageType(src string) (int, error) {
 		} else if HasMagic(f, "AI\x02", 8) {
 			// AppImage type is type 2 (standard)
 			return 2, nil
+		} else if HasMagic(f, "AI\x03", 8) {
+			// AppImage type is type 3 (AES-GCM encrypted SquashFS)
+			return 3, nil
 		}
 		// Unknown AppImage, but valid ELF
 		return 0, nil
@@ -0,0 +1,136 @@
+package helpers
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrNoTrailer is returned by FindSignatureTrailer when the AppImage has no
+// appended zip-style signature slot
+var ErrNoTrailer = errors.New("AppImage has no signature trailer")
+
+// FindSignatureTrailer looks for a zip-style central directory appended
+// after the AppImage's SquashFS payload (the standard AppImage signature
+// slot) and returns the raw contents of its `.sig` and `.sig.key` members.
+// archive/zip locates the end-of-central-directory record by scanning back
+// from EOF, so this works regardless of the payload's length
+func FindSignatureTrailer(src string) (sig []byte, key []byte, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, nil, ErrNoTrailer
+	}
+
+	for _, zf := range zr.File {
+		switch zf.Name {
+		case ".sig":
+			sig, err = readZipFile(zf)
+		case ".sig.key":
+			key, err = readZipFile(zf)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if sig == nil {
+		return nil, nil, ErrNoTrailer
+	}
+
+	return sig, key, nil
+}
+
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// FindRecipients returns the raw `.recipients` member of the AppImage's
+// signature trailer (one X25519-wrapped data key entry per line), or
+// ErrNoTrailer if there's no trailer at all
+func FindRecipients(src string) ([]byte, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, ErrNoTrailer
+	}
+
+	for _, zf := range zr.File {
+		if zf.Name == ".recipients" {
+			return readZipFile(zf)
+		}
+	}
+
+	return nil, ErrNoTrailer
+}
+
+// ReadUntilTrailer returns the bytes of `src` up to (but not including) its
+// appended zip-style signature trailer, i.e. everything the signature in
+// that trailer was computed over
+func ReadUntilTrailer(src string) ([]byte, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return nil, ErrNoTrailer
+	}
+
+	trailerStart := fi.Size()
+	for _, zf := range zr.File {
+		off, err := zf.DataOffset()
+		if err != nil {
+			continue
+		}
+
+		// Back out the local file header to find where this entry (and so
+		// the trailer as a whole) actually begins on disk
+		headerStart := off - int64(len(zf.Name)) - 30
+		if headerStart < trailerStart {
+			trailerStart = headerStart
+		}
+	}
+
+	buf := make([]byte, trailerStart)
+	if _, err := io.ReadFull(io.NewSectionReader(f, 0, trailerStart), buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
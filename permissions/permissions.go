@@ -0,0 +1,14 @@
+// Package permissions describes the sandbox restrictions applied to an
+// AppImage: its level plus the files, devices, sockets and shares it's
+// allowed to reach.
+package permissions
+
+// AppImagePerms holds the sandbox permissions used to launch an AppImage,
+// either read from its desktop entry or overridden by the caller
+type AppImagePerms struct {
+	Level   int
+	Files   []string
+	Devices []string
+	Sockets []string
+	Share   []string
+}
@@ -0,0 +1,48 @@
+package permissions
+
+// UidRange maps a contiguous block of `Count` host uids/gids starting at
+// `HostStart` into the sandbox's user namespace starting at `SandboxStart`,
+// following the same (start, count) shape as rkt's UidRange. A `Count` of 1
+// just remaps the invoking uid; a wider count reserves a subordinate id
+// space for nested tools inside the AppImage (fakeroot, chroot helpers).
+type UidRange struct {
+	HostStart    int
+	SandboxStart int
+	Count        int
+}
+
+// Blank returns a UidRange that maps only `hostUid` to `sandboxUid`, with no
+// subordinate range reserved for nested tools
+func Blank(hostUid, sandboxUid int) UidRange {
+	return UidRange{
+		HostStart:    hostUid,
+		SandboxStart: sandboxUid,
+		Count:        1,
+	}
+}
+
+// Map translates a host uid into its sandbox-side counterpart, returning
+// false if the uid falls outside the range
+func (r UidRange) Map(hostUid int) (int, bool) {
+	if r.Count == 0 || hostUid < r.HostStart || hostUid >= r.HostStart+r.Count {
+		return 0, false
+	}
+
+	return r.SandboxStart + (hostUid - r.HostStart), true
+}
+
+// Grow returns a copy of r extended (if necessary) so hostUid falls inside
+// it, keeping the same HostStart/SandboxStart offset. hostUid values below
+// HostStart are returned unchanged, since shifting the base would change
+// the sandbox uid already assigned to HostStart itself
+func (r UidRange) Grow(hostUid int) UidRange {
+	if hostUid < r.HostStart {
+		return r
+	}
+
+	if need := hostUid - r.HostStart + 1; need > r.Count {
+		r.Count = need
+	}
+
+	return r
+}
@@ -0,0 +1,62 @@
+package permissions
+
+import "testing"
+
+func TestBlankMapsOnlyTheGivenUid(t *testing.T) {
+	r := Blank(1000, 256)
+
+	mapped, ok := r.Map(1000)
+	if !ok || mapped != 256 {
+		t.Fatalf("Map(1000) = %d, %v; want 256, true", mapped, ok)
+	}
+
+	if _, ok := r.Map(1001); ok {
+		t.Fatalf("Map(1001) should miss on a blank range")
+	}
+}
+
+func TestMapOffsetsWithinRange(t *testing.T) {
+	r := UidRange{HostStart: 1000, SandboxStart: 0, Count: 10}
+
+	mapped, ok := r.Map(1005)
+	if !ok || mapped != 5 {
+		t.Fatalf("Map(1005) = %d, %v; want 5, true", mapped, ok)
+	}
+
+	if _, ok := r.Map(1010); ok {
+		t.Fatalf("Map(1010) should be out of range (exclusive upper bound)")
+	}
+
+	if _, ok := r.Map(999); ok {
+		t.Fatalf("Map(999) should be out of range (below HostStart)")
+	}
+}
+
+func TestGrowExtendsCountToCoverUid(t *testing.T) {
+	r := Blank(1000, 256)
+
+	r = r.Grow(1005)
+	if r.Count != 6 {
+		t.Fatalf("Count = %d; want 6 after growing to cover 1005", r.Count)
+	}
+
+	mapped, ok := r.Map(1005)
+	if !ok || mapped != 261 {
+		t.Fatalf("Map(1005) = %d, %v; want 261, true", mapped, ok)
+	}
+
+	// Growing to a uid already covered is a no-op
+	grown := r.Grow(1002)
+	if grown.Count != r.Count {
+		t.Fatalf("Grow(1002) changed Count from %d to %d", r.Count, grown.Count)
+	}
+}
+
+func TestGrowIgnoresUidsBelowHostStart(t *testing.T) {
+	r := Blank(1000, 256)
+
+	grown := r.Grow(500)
+	if grown != r {
+		t.Fatalf("Grow(500) should leave the range untouched, got %+v", grown)
+	}
+}
@@ -0,0 +1,137 @@
+package aisap
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	openpgp "github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+
+	helpers "github.com/mgord9518/aisap/helpers"
+)
+
+var (
+	// ErrUnsigned is returned by Verify when the AppImage has no `.sig`
+	// member in its signature trailer
+	ErrUnsigned = errors.New("AppImage is not signed")
+
+	// ErrBadSignature is returned by Verify when a signature is present but
+	// doesn't validate against the bytes preceding the trailer
+	ErrBadSignature = errors.New("AppImage signature is invalid")
+
+	// ErrUntrustedKey is returned by Verify when the signature validates
+	// but the signing key isn't one of the caller's trusted keyring entries
+	ErrUntrustedKey = errors.New("AppImage was signed by an untrusted key")
+)
+
+// Verify checks the AppImage's appended signature trailer (see
+// `helpers.FindSignatureTrailer`) against the bytes preceding it. It
+// succeeds only when the signature validates against a key in `keyring`; a
+// signature that validates only against the trailer's embedded `.sig.key`
+// gives ErrUntrustedKey. Supports both detached OpenPGP signatures and
+// minisign
+func (ai AppImage) Verify(keyring []string) error {
+	sig, embeddedKey, err := helpers.FindSignatureTrailer(ai.Path)
+	if err == helpers.ErrNoTrailer {
+		return ErrUnsigned
+	} else if err != nil {
+		return err
+	}
+
+	// The signed range is everything up to where the trailer was appended,
+	// which FindSignatureTrailer already validated exists
+	signedBytes, err := helpers.ReadUntilTrailer(ai.Path)
+	if err != nil {
+		return err
+	}
+
+	validates := func(k string) bool {
+		return verifyMinisign(signedBytes, sig, k) || verifyOpenPGP(signedBytes, sig, k)
+	}
+
+	// A signature that validates against a caller-supplied keyring entry is
+	// trusted outright, regardless of what key (if any) is embedded in the
+	// trailer
+	for _, k := range keyring {
+		if validates(k) {
+			return nil
+		}
+	}
+
+	// It didn't validate against anything the caller trusts. If the trailer
+	// embeds its own key and the signature validates against *that*, we know
+	// who signed it -- they're just not in the keyring
+	if embeddedKey != nil && validates(string(embeddedKey)) {
+		return ErrUntrustedKey
+	}
+
+	return ErrBadSignature
+}
+
+// verifyMinisign checks a minisign Ed25519 signature (base64, one line)
+// against `pubkey` (a base64-encoded minisign public key)
+func verifyMinisign(msg, sig []byte, pubkey string) bool {
+	sigBytes, err := decodeMinisignBlob(string(sig))
+	if err != nil || len(sigBytes) != ed25519.SignatureSize+10 {
+		return false
+	}
+
+	pub, err := decodeMinisignBlob(pubkey)
+	if err != nil || len(pub) != ed25519.PublicKeySize+10 {
+		return false
+	}
+
+	// Minisign prefixes both keys and signatures with a 2-byte algorithm
+	// tag and an 8-byte key ID; skip both to get to the raw material. The
+	// tag also selects whether the message was signed directly ("Ed") or
+	// pre-hashed with BLAKE2b-512 first ("ED", minisign's mode for signing
+	// large files without buffering them whole)
+	algo := sigBytes[:2]
+	if !bytes.Equal(sigBytes[2:10], pub[2:10]) {
+		return false
+	}
+
+	toVerify := msg
+	switch string(algo) {
+	case "Ed":
+		// legacy mode: sign the message directly
+	case "ED":
+		sum := blake2b.Sum512(msg)
+		toVerify = sum[:]
+	default:
+		return false
+	}
+
+	return ed25519.Verify(pub[10:], toVerify, sigBytes[10:])
+}
+
+// decodeMinisignBlob extracts and base64-decodes the payload line of a
+// minisign signature or public key file, skipping the leading comment line
+func decodeMinisignBlob(s string) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "untrusted comment:") ||
+			strings.HasPrefix(line, "trusted comment:") {
+			continue
+		}
+
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	}
+
+	return nil, errors.New("empty minisign blob")
+}
+
+// verifyOpenPGP checks a detached OpenPGP signature against an armored
+// public key
+func verifyOpenPGP(msg, sig []byte, armoredKey string) bool {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKey))
+	if err != nil || len(keyring) == 0 {
+		return false
+	}
+
+	_, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(msg), bytes.NewReader(sig), nil)
+	return err == nil
+}